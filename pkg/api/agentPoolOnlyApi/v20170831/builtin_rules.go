@@ -0,0 +1,187 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package v20170831
+
+import (
+	"github.com/Azure/acs-engine/pkg/api/agentPoolOnlyApi/v20170831/rules"
+	"github.com/Azure/acs-engine/pkg/api/common"
+	"github.com/pkg/errors"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// init registers the built-in validation rules run by Properties.Validate(). Operators embedding
+// acs-engine as a library can call rules.UnregisterRule to disable any of these (e.g.
+// "poolNameLength" to lift the 12-character pool name cap) or rules.RegisterRule to add their
+// own.
+func init() {
+	rules.RegisterRule(structFieldsRule{})
+	rules.RegisterRule(dnsPrefixRule{})
+	rules.RegisterRule(uniqueProfileNamesRule{})
+	rules.RegisterRule(poolNameFormatRule{})
+	rules.RegisterRule(poolNameLengthRule{})
+	rules.RegisterRule(computerNameLengthRule{})
+	rules.RegisterRule(linuxProfileRule{})
+	rules.RegisterRule(vnetConsistencyRule{})
+	rules.RegisterRule(subnetCapacityRule{})
+}
+
+func asProperties(obj interface{}) (*Properties, error) {
+	a, ok := obj.(*Properties)
+	if !ok {
+		return nil, errors.Errorf("rule engine invoked with unexpected type %T, expected *Properties", obj)
+	}
+	return a, nil
+}
+
+// structFieldsRule runs the validator.v9 struct tags on Properties and translates any failure
+// into the friendly messages handleValidationErrors has always produced.
+type structFieldsRule struct{}
+
+func (structFieldsRule) Name() string { return "structFields" }
+
+func (structFieldsRule) Check(obj interface{}) error {
+	a, err := asProperties(obj)
+	if err != nil {
+		return err
+	}
+	if e := validate.Struct(a); e != nil {
+		return handleValidationErrors(e.(validator.ValidationErrors))
+	}
+	return nil
+}
+
+// dnsPrefixRule wraps common.ValidateDNSPrefix.
+type dnsPrefixRule struct{}
+
+func (dnsPrefixRule) Name() string { return "dnsPrefix" }
+
+func (dnsPrefixRule) Check(obj interface{}) error {
+	a, err := asProperties(obj)
+	if err != nil {
+		return err
+	}
+	return common.ValidateDNSPrefix(a.DNSPrefix)
+}
+
+// uniqueProfileNamesRule wraps validateUniqueProfileNames.
+type uniqueProfileNamesRule struct{}
+
+func (uniqueProfileNamesRule) Name() string { return "uniqueProfileNames" }
+
+func (uniqueProfileNamesRule) Check(obj interface{}) error {
+	a, err := asProperties(obj)
+	if err != nil {
+		return err
+	}
+	return validateUniqueProfileNames(a.AgentPoolProfiles)
+}
+
+// poolNameFormatRule enforces that every pool name starts with a lowercase letter and contains
+// only a-z0-9. Unregistering it removes the check from Properties.Validate() only;
+// AgentPoolProfile.Validate() applies validatePoolNameFormat regardless of the registry.
+type poolNameFormatRule struct{}
+
+func (poolNameFormatRule) Name() string { return "poolNameFormat" }
+
+func (poolNameFormatRule) Check(obj interface{}) error {
+	a, err := asProperties(obj)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, p := range a.AgentPoolProfiles {
+		if e := validatePoolNameFormat(p.Name); e != nil {
+			errs = append(errs, e)
+		}
+	}
+	return rules.NewMultiError(errs)
+}
+
+// poolNameLengthRule enforces maxPoolNameLength. Operators whose VM naming scheme allows longer
+// pool names may call rules.UnregisterRule("poolNameLength") to lift it.
+type poolNameLengthRule struct{}
+
+func (poolNameLengthRule) Name() string { return "poolNameLength" }
+
+func (poolNameLengthRule) Check(obj interface{}) error {
+	a, err := asProperties(obj)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, p := range a.AgentPoolProfiles {
+		if e := validatePoolNameLength(p.Name); e != nil {
+			errs = append(errs, e)
+		}
+	}
+	return rules.NewMultiError(errs)
+}
+
+// computerNameLengthRule wraps validateComputerNameLength across every agent pool.
+type computerNameLengthRule struct{}
+
+func (computerNameLengthRule) Name() string { return "computerNameLength" }
+
+func (computerNameLengthRule) Check(obj interface{}) error {
+	a, err := asProperties(obj)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, p := range a.AgentPoolProfiles {
+		if e := validateComputerNameLength(a.DNSPrefix, p); e != nil {
+			errs = append(errs, e)
+		}
+	}
+	return rules.NewMultiError(errs)
+}
+
+// linuxProfileRule wraps LinuxProfile.Validate(), which may be nil when auto-generated in newer
+// API versions (the GET response omits it in that case).
+type linuxProfileRule struct{}
+
+func (linuxProfileRule) Name() string { return "linuxProfile" }
+
+func (linuxProfileRule) Check(obj interface{}) error {
+	a, err := asProperties(obj)
+	if err != nil {
+		return err
+	}
+	if a.LinuxProfile == nil {
+		return nil
+	}
+	return a.LinuxProfile.Validate()
+}
+
+// vnetConsistencyRule wraps validateVNET.
+type vnetConsistencyRule struct{}
+
+func (vnetConsistencyRule) Name() string { return "vnetConsistency" }
+
+func (vnetConsistencyRule) Check(obj interface{}) error {
+	a, err := asProperties(obj)
+	if err != nil {
+		return err
+	}
+	return validateVNET(a)
+}
+
+// subnetCapacityRule wraps validateSubnetCIDR. It defers to vnetConsistencyRule when the VNET
+// configuration itself is already inconsistent (e.g. a mix of custom and non-custom pools, or
+// pools referencing different VNETs): sizing a subnet that validateVNET has already rejected
+// would just fold a second, unrelated error into the same aggregated MultiError.
+type subnetCapacityRule struct{}
+
+func (subnetCapacityRule) Name() string { return "subnetCapacity" }
+
+func (subnetCapacityRule) Check(obj interface{}) error {
+	a, err := asProperties(obj)
+	if err != nil {
+		return err
+	}
+	if e := validateVNET(a); e != nil {
+		return nil
+	}
+	return validateSubnetCIDR(a)
+}
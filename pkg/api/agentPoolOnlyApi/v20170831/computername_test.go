@@ -0,0 +1,116 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package v20170831
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMaxInstanceSuffixWidth(t *testing.T) {
+	cases := []struct {
+		count int
+		want  int
+	}{
+		{count: 1, want: 1},
+		{count: 9, want: 1},
+		{count: 10, want: 1},
+		{count: 11, want: 2},
+		{count: 100, want: 2},
+		{count: 101, want: 3},
+	}
+	for _, c := range cases {
+		if got := maxInstanceSuffixWidth(c.count); got != c.want {
+			t.Errorf("maxInstanceSuffixWidth(%d) = %d, want %d", c.count, got, c.want)
+		}
+	}
+}
+
+func TestValidateComputerNameLengthWindowsBoundary(t *testing.T) {
+	dnsPrefix := strings.Repeat("d", 9)
+
+	atLimit := &AgentPoolProfile{Name: "pool1", Count: 1, OSType: Windows} // 9 + 5 + 1 = 15
+	if err := validateComputerNameLength(dnsPrefix, atLimit); err != nil {
+		t.Fatalf("expected a 15-character Windows computer name to be accepted, got %v", err)
+	}
+
+	overLimit := &AgentPoolProfile{Name: "pool12", Count: 1, OSType: Windows} // 9 + 6 + 1 = 16
+	if err := validateComputerNameLength(dnsPrefix, overLimit); err == nil {
+		t.Fatal("expected a 16-character Windows computer name to be rejected, got nil")
+	}
+}
+
+func TestValidateComputerNameLengthLinuxBoundary(t *testing.T) {
+	dnsPrefix := strings.Repeat("d", 57)
+
+	atLimit := &AgentPoolProfile{Name: "pool1", Count: 1, OSType: Linux} // 57 + 5 + 1 = 63
+	if err := validateComputerNameLength(dnsPrefix, atLimit); err != nil {
+		t.Fatalf("expected a 63-character Linux hostname to be accepted, got %v", err)
+	}
+
+	overLimit := &AgentPoolProfile{Name: "pool12", Count: 1, OSType: Linux} // 57 + 6 + 1 = 64
+	if err := validateComputerNameLength(dnsPrefix, overLimit); err == nil {
+		t.Fatal("expected a 64-character Linux hostname to be rejected, got nil")
+	}
+}
+
+// TestValidateComputerNameLengthAccountsForMultiDigitSuffix guards against validating only
+// against agentPool.Count's value rather than the suffix width it produces: crossing from a
+// single-digit to a two-digit instance suffix (Count 10 -> 11) must push an already-at-the-limit
+// name over the cap.
+func TestValidateComputerNameLengthAccountsForMultiDigitSuffix(t *testing.T) {
+	dnsPrefix := strings.Repeat("d", 9)
+	pool := &AgentPoolProfile{Name: "pool1", Count: 10, OSType: Windows} // 1-digit suffix: 9+5+1=15
+
+	if err := validateComputerNameLength(dnsPrefix, pool); err != nil {
+		t.Fatalf("expected a 1-digit instance suffix at the boundary to pass, got %v", err)
+	}
+
+	pool.Count = 11 // 2-digit suffix: 9+5+2=16
+	if err := validateComputerNameLength(dnsPrefix, pool); err == nil {
+		t.Fatal("expected crossing into a 2-digit instance suffix to push the name over the limit")
+	}
+}
+
+// TestValidateComputerNameLengthUsesFixedVMSSSuffixWidth guards against sizing a
+// VirtualMachineScaleSets pool's instance suffix off Count the way an AvailabilitySet pool is:
+// VMSS computer names get Azure's fixed-width, auto-generated unique suffix regardless of pool
+// size, so a pool of Count 1 must still be judged against the full vmssInstanceSuffixWidth, and
+// growing Count must not change the projected name length at all.
+func TestValidateComputerNameLengthUsesFixedVMSSSuffixWidth(t *testing.T) {
+	dnsPrefix := strings.Repeat("d", 4)
+
+	pool := &AgentPoolProfile{Name: "pool1", Count: 1, OSType: Windows, AvailabilityProfile: VirtualMachineScaleSets} // 4+5+6=15
+	if err := validateComputerNameLength(dnsPrefix, pool); err != nil {
+		t.Fatalf("expected a 15-character Windows computer name with a VMSS suffix to be accepted, got %v", err)
+	}
+
+	pool.Count = 1000 // still a 6-character Azure-generated suffix, not a 4-digit decimal index
+	if err := validateComputerNameLength(dnsPrefix, pool); err != nil {
+		t.Fatalf("expected growing Count on a VMSS pool not to change its projected computer name length, got %v", err)
+	}
+
+	pool.Name = "pool12" // 4+6+6=16, now over the limit
+	if err := validateComputerNameLength(dnsPrefix, pool); err == nil {
+		t.Fatal("expected the fixed VMSS suffix width to still reject an over-long computer name")
+	}
+}
+
+func TestComputerNameLengthRuleAggregatesAcrossPools(t *testing.T) {
+	props := &Properties{
+		DNSPrefix: strings.Repeat("d", 9),
+		AgentPoolProfiles: []*AgentPoolProfile{
+			{Name: "pool1", Count: 1, OSType: Windows},  // 9+5+1=15, OK
+			{Name: "pool12", Count: 1, OSType: Windows}, // 9+6+1=16, too long
+		},
+	}
+
+	err := (computerNameLengthRule{}).Check(props)
+	if err == nil {
+		t.Fatal("expected computerNameLengthRule to reject pool12's over-long computer name")
+	}
+	if !strings.Contains(err.Error(), "pool12") {
+		t.Fatalf("expected error to name the offending pool, got %v", err)
+	}
+}
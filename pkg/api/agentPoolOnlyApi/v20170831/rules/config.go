@@ -0,0 +1,51 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package rules
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// Config describes operator overrides to the default rule set: built-in rules to disable, by
+// name (e.g. "poolNameLength" to lift the 12-character pool name cap).
+type Config struct {
+	DisabledRules []string `json:"disabledRules,omitempty" yaml:"disabledRules,omitempty"`
+}
+
+// LoadConfig reads a Config from a JSON (.json) or YAML (.yaml/.yml) file at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read rule config '%s'", path)
+	}
+
+	var cfg Config
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, errors.Wrapf(err, "could not parse rule config '%s' as YAML", path)
+		}
+	case ".json":
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, errors.Wrapf(err, "could not parse rule config '%s' as JSON", path)
+		}
+	default:
+		return nil, errors.Errorf("unsupported rule config extension '%s', must be .json, .yaml, or .yml", ext)
+	}
+
+	return &cfg, nil
+}
+
+// Apply unregisters every rule named in cfg.DisabledRules.
+func (cfg *Config) Apply() {
+	for _, name := range cfg.DisabledRules {
+		UnregisterRule(name)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+// Package rules implements a pluggable validation rule engine for the v20170831 agent-pool-only
+// API. Built-in rules register themselves via init() in the v20170831 package; callers embedding
+// acs-engine as a library (e.g. in a CI pipeline) can disable specific built-in rules or inject
+// organization-specific rules (naming conventions, required tags, approved VM SKU allow-lists)
+// without forking the repo.
+package rules
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Rule validates an API object and returns an error describing any violation it finds, or nil if
+// the object is valid. obj is passed as interface{} (rather than a concrete *Properties) so this
+// package has no dependency on the API package whose rules register here; avoids an import cycle.
+type Rule interface {
+	// Name uniquely identifies the rule, e.g. "poolNameLength". Used to disable or replace it.
+	Name() string
+	Check(obj interface{}) error
+}
+
+var (
+	mu       sync.Mutex
+	registry = map[string]Rule{}
+	order    []string
+)
+
+// RegisterRule adds r to the set of rules run by Validate. Registering under a name that is
+// already registered replaces the existing rule in place, preserving its position in the run
+// order.
+func RegisterRule(r Rule) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := registry[r.Name()]; !ok {
+		order = append(order, r.Name())
+	}
+	registry[r.Name()] = r
+}
+
+// UnregisterRule removes the rule registered under name, if any. It is a no-op if name is not
+// registered, so operators can safely disable a rule that may already be absent.
+func UnregisterRule(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := registry[name]; !ok {
+		return
+	}
+	delete(registry, name)
+	for i, n := range order {
+		if n == name {
+			order = append(order[:i], order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Registered reports the names of all currently registered rules, in registration order.
+func Registered() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	out := make([]string, len(order))
+	copy(out, order)
+	return out
+}
+
+// IsRegistered reports whether a rule named name is currently registered. Callers that enforce a
+// built-in check outside of Validate (e.g. a convenience method implementing APIObject on a
+// sub-type) can use this to honor UnregisterRule instead of hard-coding the check.
+func IsRegistered(name string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	_, ok := registry[name]
+	return ok
+}
+
+// ruleFailure pairs a rule's name with the error it returned, so Validate can decide how to
+// format it (bare vs. rule-name-prefixed) only once it knows how many rules failed overall.
+type ruleFailure struct {
+	name string
+	err  error
+}
+
+// Validate runs every registered rule against obj and aggregates every error encountered into a
+// single MultiError, rather than returning only the first rule that fails. When exactly one rule
+// fails, its error is returned as-is, unwrapped, preserving the message callers have always
+// gotten from Properties.Validate() for a single failure; the "rule %q" prefix and MultiError
+// aggregation only kick in once there is more than one failure to report.
+func Validate(obj interface{}) error {
+	mu.Lock()
+	names := make([]string, len(order))
+	copy(names, order)
+	mu.Unlock()
+
+	var failures []ruleFailure
+	for _, name := range names {
+		mu.Lock()
+		r := registry[name]
+		mu.Unlock()
+		if r == nil {
+			continue
+		}
+		if e := r.Check(obj); e != nil {
+			failures = append(failures, ruleFailure{name: name, err: e})
+		}
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+	if len(failures) == 1 {
+		return failures[0].err
+	}
+
+	errs := make([]error, len(failures))
+	for i, f := range failures {
+		errs[i] = errors.Wrapf(f.err, "rule %q", f.name)
+	}
+	return NewMultiError(errs)
+}
@@ -0,0 +1,100 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package rules
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+type fakeRule struct {
+	name string
+	err  error
+}
+
+func (f *fakeRule) Name() string            { return f.name }
+func (f *fakeRule) Check(interface{}) error { return f.err }
+
+func resetRegistry() {
+	mu.Lock()
+	registry = map[string]Rule{}
+	order = nil
+	mu.Unlock()
+}
+
+func TestValidateAggregatesAllRuleErrors(t *testing.T) {
+	resetRegistry()
+	defer resetRegistry()
+
+	RegisterRule(&fakeRule{name: "a", err: errors.New("a failed")})
+	RegisterRule(&fakeRule{name: "b", err: nil})
+	RegisterRule(&fakeRule{name: "c", err: errors.New("c failed")})
+
+	err := Validate(nil)
+	if err == nil {
+		t.Fatal("expected an aggregated error, got nil")
+	}
+	merr, ok := err.(*MultiError)
+	if !ok {
+		t.Fatalf("expected *MultiError, got %T", err)
+	}
+	if len(merr.Errors) != 2 {
+		t.Fatalf("expected 2 errors, got %d: %v", len(merr.Errors), merr.Errors)
+	}
+}
+
+func TestUnregisterRuleDisablesIt(t *testing.T) {
+	resetRegistry()
+	defer resetRegistry()
+
+	RegisterRule(&fakeRule{name: "a", err: errors.New("a failed")})
+	UnregisterRule("a")
+
+	if err := Validate(nil); err != nil {
+		t.Fatalf("expected no error after unregistering the only failing rule, got %v", err)
+	}
+}
+
+func TestRegisterRuleCustomInjection(t *testing.T) {
+	resetRegistry()
+	defer resetRegistry()
+
+	RegisterRule(&fakeRule{name: "orgNamingConvention", err: errors.New("name must start with 'org-'")})
+
+	if err := Validate(nil); err == nil {
+		t.Fatal("expected injected custom rule to fail validation")
+	}
+}
+
+func TestConfigApplyDisablesRulesByName(t *testing.T) {
+	resetRegistry()
+	defer resetRegistry()
+
+	RegisterRule(&fakeRule{name: "poolNameLength", err: errors.New("pool name too long")})
+
+	dir, err := ioutil.TempDir("", "rules-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.json")
+	if err := ioutil.WriteFile(path, []byte(`{"disabledRules":["poolNameLength"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	cfg.Apply()
+
+	if err := Validate(nil); err != nil {
+		t.Fatalf("expected poolNameLength rule to be disabled, got %v", err)
+	}
+}
@@ -4,16 +4,74 @@
 package v20170831
 
 import (
+	"context"
+	"crypto/rsa"
+	"net"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Azure/acs-engine/pkg/api/agentPoolOnlyApi/v20170831/rules"
 	"github.com/Azure/acs-engine/pkg/api/common"
+	"github.com/Azure/azure-sdk-for-go/services/network/mgmt/2017-09-01/network"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
 	"gopkg.in/go-playground/validator.v9"
 )
 
+// subnetLookupTimeout bounds the ARM call validateSubnetCIDR makes to look up a subnet's
+// capacity, so a slow or unreachable ARM endpoint fails validation instead of hanging.
+const subnetLookupTimeout = 30 * time.Second
+
 var validate *validator.Validate
 
+// numReservedAzureIPAddresses is the number of IP addresses Azure reserves in every subnet
+// (the network, gateway, the two DNS addresses, and the broadcast address).
+const numReservedAzureIPAddresses = 5
+
+// subnetCIDRGetter abstracts the Azure API call used to look up a subnet's address prefix and how
+// many IP configurations are already allocated in it, so the pre-flight sizing check in
+// validateSubnetCIDR can be faked in tests.
+type subnetCIDRGetter interface {
+	// GetSubnet returns the subnet's address prefix and the number of IP configurations (NICs)
+	// already using addresses within it.
+	GetSubnet(subscriptionID, resourceGroup, vnetName, subnetName string) (cidr string, allocatedIPs int, err error)
+}
+
+// azureSubnetCIDRGetter is the production subnetCIDRGetter backed by the Azure network API.
+type azureSubnetCIDRGetter struct{}
+
+func (azureSubnetCIDRGetter) GetSubnet(subscriptionID, resourceGroup, vnetName, subnetName string) (string, int, error) {
+	authorizer, err := auth.NewAuthorizerFromEnvironment()
+	if err != nil {
+		return "", 0, errors.Wrap(err, "could not create an ARM authorizer to look up subnet capacity")
+	}
+
+	client := network.NewSubnetsClient(subscriptionID)
+	client.Authorizer = authorizer
+
+	ctx, cancel := context.WithTimeout(context.Background(), subnetLookupTimeout)
+	defer cancel()
+
+	subnet, err := client.Get(ctx, resourceGroup, vnetName, subnetName, "")
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "could not retrieve subnet '%s' on VNET '%s'", subnetName, vnetName)
+	}
+	if subnet.SubnetPropertiesFormat == nil || subnet.AddressPrefix == nil {
+		return "", 0, errors.Errorf("subnet '%s' on VNET '%s' has no address prefix", subnetName, vnetName)
+	}
+	var allocatedIPs int
+	if subnet.IPConfigurations != nil {
+		allocatedIPs = len(*subnet.IPConfigurations)
+	}
+	return *subnet.AddressPrefix, allocatedIPs, nil
+}
+
+// subnetCIDR is the subnetCIDRGetter used by validateSubnetCIDR. Tests swap it out for a fake.
+var subnetCIDR subnetCIDRGetter = azureSubnetCIDRGetter{}
+
 func init() {
 	validate = validator.New()
 }
@@ -22,16 +80,87 @@ func init() {
 func (a *AgentPoolProfile) Validate() error {
 	// Don't need to call validate.Struct(a)
 	// It is handled by Properties.Validate()
-	return validatePoolName(a.Name)
+	if e := validatePoolNameFormat(a.Name); e != nil {
+		return e
+	}
+	// The length cap is enforced by poolNameLengthRule when going through Properties.Validate(),
+	// so honor rules.UnregisterRule("poolNameLength") here too rather than re-imposing a cap
+	// operators have disabled through the rule registry.
+	if rules.IsRegistered("poolNameLength") {
+		return validatePoolNameLength(a.Name)
+	}
+	return nil
+}
+
+// minRSAKeyBits is the minimum accepted RSA key size, in bits.
+const minRSAKeyBits = 2048
+
+// AllowedSSHKeyAlgorithms is the configurable allow-list of SSH public key algorithms accepted
+// by LinuxProfile.Validate(). It is exported so operators running acs-engine as a library can
+// mutate it at startup to tighten or relax which algorithms are accepted (e.g. drop ssh-rsa
+// entirely, or add an org-specific algorithm); RSA keys are additionally checked against
+// minRSAKeyBits.
+var AllowedSSHKeyAlgorithms = map[string]bool{
+	ssh.KeyAlgoRSA:      true,
+	ssh.KeyAlgoED25519:  true,
+	ssh.KeyAlgoECDSA256: true,
+	ssh.KeyAlgoECDSA384: true,
+	ssh.KeyAlgoECDSA521: true,
 }
 
 // Validate implements APIObject
 func (l *LinuxProfile) Validate() error {
 	// Don't need to call validate.Struct(l)
 	// It is handled by Properties.Validate()
-	if e := validate.Var(l.SSH.PublicKeys[0].KeyData, "required"); e != nil {
+	if len(l.SSH.PublicKeys) == 0 {
 		return errors.New("KeyData in LinuxProfile.SSH.PublicKeys cannot be empty string")
 	}
+	for i, publicKey := range l.SSH.PublicKeys {
+		if e := validateSSHPublicKey(publicKey.KeyData); e != nil {
+			return errors.Wrapf(e, "LinuxProfile.SSH.PublicKeys[%d] is invalid", i)
+		}
+	}
+	return nil
+}
+
+// validateSSHPublicKey parses keyData as an authorized-keys-format SSH public key, rejecting
+// anything that fails to parse (e.g. a PEM private key pasted by mistake, or a truncated key) or
+// whose algorithm is not in AllowedSSHKeyAlgorithms.
+func validateSSHPublicKey(keyData string) error {
+	if strings.TrimSpace(keyData) == "" {
+		return errors.New("KeyData cannot be empty string")
+	}
+
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(keyData))
+	if err != nil {
+		return errors.Wrap(err, "could not parse KeyData as an SSH public key")
+	}
+
+	algo := pubKey.Type()
+	if !AllowedSSHKeyAlgorithms[algo] {
+		return errors.Errorf("key algorithm '%s' is not in the allowed list", algo)
+	}
+
+	if algo == ssh.KeyAlgoRSA {
+		return validateRSAKeySize(pubKey)
+	}
+
+	return nil
+}
+
+// validateRSAKeySize rejects RSA keys smaller than minRSAKeyBits.
+func validateRSAKeySize(pubKey ssh.PublicKey) error {
+	cryptoKey, ok := pubKey.(ssh.CryptoPublicKey)
+	if !ok {
+		return errors.New("could not determine RSA key size")
+	}
+	rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey)
+	if !ok {
+		return errors.New("could not determine RSA key size")
+	}
+	if rsaKey.N.BitLen() < minRSAKeyBits {
+		return errors.Errorf("RSA key is %d bits, must be at least %d bits", rsaKey.N.BitLen(), minRSAKeyBits)
+	}
 	return nil
 }
 
@@ -63,48 +192,93 @@ func handleValidationErrors(e validator.ValidationErrors) error {
 	return errors.Errorf("Namespace %s is not caught, %+v", ns, e)
 }
 
-// Validate implements APIObject
+// Validate implements APIObject. The individual checks are registered, built-in rules.Rule
+// implementations (see builtin_rules.go); this just hands Properties.Validate() off to the
+// registry so operators embedding acs-engine as a library can disable or extend the rule set
+// without forking this method.
 func (a *Properties) Validate() error {
-	if e := validate.Struct(a); e != nil {
-		return handleValidationErrors(e.(validator.ValidationErrors))
-	}
+	return rules.Validate(a)
+}
 
-	// Don't need to call validate.Struct(m)
-	// It is handled by Properties.Validate()
-	if e := common.ValidateDNSPrefix(a.DNSPrefix); e != nil {
-		return e
+// maxPoolNameLength is the historical cap on pool name length; it feeds into the VMName and is
+// enforced by poolNameLengthRule, which operators may disable via rules.UnregisterRule if they
+// construct VM names differently.
+const maxPoolNameLength = 12
+
+var poolNameFormatRegex = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
+// validatePoolNameFormat enforces that a pool name starts with a lowercase letter and contains
+// only a-z0-9. Unlike the length cap, AgentPoolProfile.Validate() applies it without consulting
+// the rule registry: it is intrinsic to a valid VMName.
+func validatePoolNameFormat(poolName string) error {
+	if !poolNameFormatRegex.MatchString(poolName) {
+		return errors.Errorf("pool name '%s' is invalid. A pool name must start with a lowercase letter and only have characters a-z0-9", poolName)
 	}
+	return nil
+}
 
-	if e := validateUniqueProfileNames(a.AgentPoolProfiles); e != nil {
-		return e
+// validatePoolNameLength enforces maxPoolNameLength.
+func validatePoolNameLength(poolName string) error {
+	if len(poolName) > maxPoolNameLength {
+		return errors.Errorf("pool name '%s' is invalid. A pool name must have max length of %d", poolName, maxPoolNameLength)
 	}
+	return nil
+}
 
-	for _, agentPoolProfile := range a.AgentPoolProfiles {
-		if e := agentPoolProfile.Validate(); e != nil {
-			return e
-		}
+// windowsComputerNameMaxLength and linuxHostnameMaxLength are the OS-specific limits Azure
+// enforces on a VM's computer name / hostname.
+const (
+	windowsComputerNameMaxLength = 15
+	linuxHostnameMaxLength       = 63
+)
+
+// vmssInstanceSuffixWidth is how many characters Azure itself appends to a VM Scale Set's
+// computerNamePrefix to build each instance's unique computer name. Unlike an AvailabilitySet
+// pool's sequential decimal index, this suffix is an Azure-generated value unrelated to Count, so
+// it is a fixed width rather than something acs-engine derives from pool size.
+const vmssInstanceSuffixWidth = 6
+
+// maxInstanceSuffixWidth returns how many characters acs-engine appends to a pool name to build
+// the final VM name for an AvailabilitySet pool of the given size (a zero-based instance index, so
+// a pool of count 10 needs a 1-digit suffix up to index 9 but a pool of count 11 needs 2 digits
+// for index 10).
+func maxInstanceSuffixWidth(count int) int {
+	if count <= 1 {
+		return 1
 	}
+	return len(strconv.Itoa(count - 1))
+}
 
-	// It may be nil when LinuxProfile is auto-generated in newer api version
-	// hence the GET uwill not include this propery
-	if a.LinuxProfile != nil {
-		if e := a.LinuxProfile.Validate(); e != nil {
-			return e
-		}
+// instanceSuffixWidth returns how many characters are appended to agentPool's name to build the
+// final VM computer name, accounting for the two distinct naming mechanisms acs-engine uses:
+// AvailabilitySet pools get a sequential decimal instance index sized off Count, while
+// VirtualMachineScaleSets pools get Azure's fixed-width, auto-generated unique suffix instead.
+func instanceSuffixWidth(agentPool *AgentPoolProfile) int {
+	if agentPool.AvailabilityProfile == VirtualMachineScaleSets {
+		return vmssInstanceSuffixWidth
 	}
-	return validateVNET(a)
+	return maxInstanceSuffixWidth(agentPool.Count)
 }
 
-func validatePoolName(poolName string) error {
-	// we will cap at length of 12 and all lowercase letters since this makes up the VMName
-	poolNameRegex := `^([a-z][a-z0-9]{0,11})$`
-	re, err := regexp.Compile(poolNameRegex)
-	if err != nil {
-		return err
+// validateComputerNameLength rejects an agent pool whose projected VM computer name -- DNS
+// prefix + pool name + instance suffix -- would exceed the OS-specific cap Azure enforces (15
+// characters for Windows, 63 for Linux), so this is caught here instead of failing deep inside
+// VM provisioning.
+func validateComputerNameLength(dnsPrefix string, agentPool *AgentPoolProfile) error {
+	suffixWidth := instanceSuffixWidth(agentPool)
+	nameLength := len(dnsPrefix) + len(agentPool.Name) + suffixWidth
+
+	if agentPool.OSType == Windows {
+		if nameLength > windowsComputerNameMaxLength {
+			return errors.Errorf("agent pool '%s' would produce a Windows computer name %d characters long (dnsPrefix '%s' + pool name '%s' + %d-character instance suffix), which exceeds the %d character limit for Windows computer names",
+				agentPool.Name, nameLength, dnsPrefix, agentPool.Name, suffixWidth, windowsComputerNameMaxLength)
+		}
+		return nil
 	}
-	submatches := re.FindStringSubmatch(poolName)
-	if len(submatches) != 2 {
-		return errors.Errorf("pool name '%s' is invalid. A pool name must start with a lowercase letter, have max length of 12, and only have characters a-z0-9", poolName)
+
+	if nameLength > linuxHostnameMaxLength {
+		return errors.Errorf("agent pool '%s' would produce a Linux hostname %d characters long (dnsPrefix '%s' + pool name '%s' + %d-character instance suffix), which exceeds the %d character limit for Linux hostnames",
+			agentPool.Name, nameLength, dnsPrefix, agentPool.Name, suffixWidth, linuxHostnameMaxLength)
 	}
 	return nil
 }
@@ -149,11 +323,94 @@ func validateVNET(a *Properties) error {
 			agentVNETMap[agentVNET] = agentVNETMap[agentVNET] + 1
 		}
 
-		// TODO: Add more validation to ensure all agent pools belong to the same VNET, subscription, and resource group
-		// 	if(len(subIDMap) != len(a.AgentPoolProfiles))
+		if len(subIDMap) > 1 || len(resourceGroupMap) > 1 || len(agentVNETMap) > 1 {
+			return buildVNETMismatchError(a.AgentPoolProfiles)
+		}
+	}
+
+	return nil
+}
+
+// buildVNETMismatchError reports the first agent pool that deviates from the VNET referenced by
+// the first custom-VNET pool (later deviating pools are not listed), so users don't have to cross-reference subIDMap/resourceGroupMap/
+// agentVNETMap themselves to find the offending pool.
+func buildVNETMismatchError(profiles []*AgentPoolProfile) error {
+	var wantSubID, wantRG, wantVNET, wantPoolName string
+	for _, agentPool := range profiles {
+		subID, rg, vnetName, _, err := common.GetVNETSubnetIDComponents(agentPool.VnetSubnetID)
+		if err != nil {
+			return err
+		}
+
+		if wantPoolName == "" {
+			wantSubID, wantRG, wantVNET, wantPoolName = subID, rg, vnetName, agentPool.Name
+			continue
+		}
+
+		if subID != wantSubID || rg != wantRG || vnetName != wantVNET {
+			return errors.Errorf("agent pool '%s' references VNET '%s' in resource group '%s' (subscription '%s'), but agent pool '%s' references VNET '%s' in resource group '%s' (subscription '%s'). All agent pools must reference the same subscription, resource group, and VNET name; different subnets within that VNET are allowed",
+				agentPool.Name, vnetName, rg, subID, wantPoolName, wantVNET, wantRG, wantSubID)
+		}
+	}
+	return errors.New("Multiple VNETs specified.  Each agent pool must reference the same VNET (but it is ok to reference different subnets on that VNET)")
+}
+
+// validateSubnetCIDR performs a pre-flight check that every custom VNET subnet referenced by
+// the agent pools has enough free IP addresses for the agent nodes routed to it, so under-sized
+// subnets are caught here instead of deep inside ARM template execution. Pools may reference
+// distinct subnets within the same VNET (validateVNET allows this), so capacity is summed and
+// checked per subnet rather than against the total node count across all pools. Free capacity is
+// measured against the subnet's actual address usage (Azure's reserved addresses plus whatever is
+// already allocated to existing IP configurations), not just the raw size of its CIDR block,
+// since a subnet can already be full even though its block is large.
+func validateSubnetCIDR(a *Properties) error {
+	var isCustomVNET bool
+	countBySubnet := make(map[string]int)
+	for _, agentPool := range a.AgentPoolProfiles {
+		if agentPool.IsCustomVNET() {
+			isCustomVNET = true
+			countBySubnet[agentPool.VnetSubnetID] += agentPool.Count
+		}
+	}
+	if !isCustomVNET {
+		return nil
+	}
+
+	checkedSubnets := make(map[string]bool)
+	for _, agentPool := range a.AgentPoolProfiles {
+		// Pools without a custom VNET have no VnetSubnetID to parse or size-check; skip them.
+		// (A mix of custom and non-custom pools is itself rejected by validateVNET.)
+		if !agentPool.IsCustomVNET() {
+			continue
+		}
+		if checkedSubnets[agentPool.VnetSubnetID] {
+			continue
+		}
+		checkedSubnets[agentPool.VnetSubnetID] = true
+
+		subID, rg, vnetName, subnetName, err := common.GetVNETSubnetIDComponents(agentPool.VnetSubnetID)
+		if err != nil {
+			return err
+		}
+
+		cidr, allocatedIPs, err := subnetCIDR.GetSubnet(subID, rg, vnetName, subnetName)
+		if err != nil {
+			return errors.Wrapf(err, "unable to validate capacity of subnet '%s' referenced by agent pool '%s'", agentPool.VnetSubnetID, agentPool.Name)
+		}
 
-		// 	return errors.New("Multiple VNETS specified.  Each agent pool must reference the same VNET (but it is ok to reference different subnets on that VNET)")
-		// }
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return errors.Wrapf(err, "could not parse CIDR '%s' for subnet '%s'", cidr, agentPool.VnetSubnetID)
+		}
+
+		ones, bits := ipNet.Mask.Size()
+		blockSize := 1 << uint(bits-ones)
+		available := blockSize - numReservedAzureIPAddresses - allocatedIPs
+		subnetCount := countBySubnet[agentPool.VnetSubnetID]
+		if available < subnetCount {
+			return errors.Errorf("subnet '%s' (%s) referenced by agent pool '%s' does not have enough capacity for the %d agent nodes routed to it (%d addresses already allocated, %d reserved by Azure, only %d of %d addresses available)",
+				agentPool.VnetSubnetID, cidr, agentPool.Name, subnetCount, allocatedIPs, numReservedAzureIPAddresses, available, blockSize)
+		}
 	}
 
 	return nil
@@ -0,0 +1,232 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT license.
+
+package v20170831
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Azure/acs-engine/pkg/api/agentPoolOnlyApi/v20170831/rules"
+)
+
+func vnetSubnetID(subID, rg, vnetName, subnetName string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/virtualNetworks/%s/subnets/%s", subID, rg, vnetName, subnetName)
+}
+
+func TestValidateVNETDetectsCrossPoolMismatch(t *testing.T) {
+	props := &Properties{
+		DNSPrefix: "dnsprefix",
+		AgentPoolProfiles: []*AgentPoolProfile{
+			{Name: "pool1", Count: 3, OSType: Linux, VnetSubnetID: vnetSubnetID("sub1", "rg1", "vnet1", "subnetA")},
+			{Name: "pool2", Count: 3, OSType: Linux, VnetSubnetID: vnetSubnetID("sub1", "rg1", "vnet2", "subnetB")},
+		},
+	}
+
+	err := validateVNET(props)
+	if err == nil {
+		t.Fatal("expected an error for agent pools referencing different VNETs, got nil")
+	}
+	if !strings.Contains(err.Error(), "pool2") {
+		t.Fatalf("expected error to identify the deviating pool 'pool2', got %v", err)
+	}
+}
+
+func TestValidateVNETAllowsSameVNETDifferentSubnets(t *testing.T) {
+	props := &Properties{
+		DNSPrefix: "dnsprefix",
+		AgentPoolProfiles: []*AgentPoolProfile{
+			{Name: "pool1", Count: 3, OSType: Linux, VnetSubnetID: vnetSubnetID("sub1", "rg1", "vnet1", "subnetA")},
+			{Name: "pool2", Count: 3, OSType: Linux, VnetSubnetID: vnetSubnetID("sub1", "rg1", "vnet1", "subnetB")},
+		},
+	}
+
+	if err := validateVNET(props); err != nil {
+		t.Fatalf("expected pools sharing a VNET with different subnets to be valid, got %v", err)
+	}
+}
+
+type fakeSubnetCIDRGetter struct {
+	cidr         string
+	allocatedIPs int
+	err          error
+}
+
+func (f fakeSubnetCIDRGetter) GetSubnet(subscriptionID, resourceGroup, vnetName, subnetName string) (string, int, error) {
+	return f.cidr, f.allocatedIPs, f.err
+}
+
+func withFakeSubnetCIDR(t *testing.T, f fakeSubnetCIDRGetter) {
+	t.Helper()
+	original := subnetCIDR
+	subnetCIDR = f
+	t.Cleanup(func() { subnetCIDR = original })
+}
+
+func TestValidateSubnetCIDRPassesWhenCapacitySufficient(t *testing.T) {
+	withFakeSubnetCIDR(t, fakeSubnetCIDRGetter{cidr: "10.0.0.0/24"})
+
+	props := &Properties{
+		DNSPrefix: "dnsprefix",
+		AgentPoolProfiles: []*AgentPoolProfile{
+			{Name: "pool1", Count: 3, OSType: Linux, VnetSubnetID: vnetSubnetID("sub1", "rg1", "vnet1", "subnetA")},
+		},
+	}
+
+	if err := validateSubnetCIDR(props); err != nil {
+		t.Fatalf("expected a /24 subnet to have enough capacity for 3 nodes, got %v", err)
+	}
+}
+
+func TestValidateSubnetCIDRFailsWhenSubnetTooSmall(t *testing.T) {
+	withFakeSubnetCIDR(t, fakeSubnetCIDRGetter{cidr: "10.0.0.0/30"})
+
+	props := &Properties{
+		DNSPrefix: "dnsprefix",
+		AgentPoolProfiles: []*AgentPoolProfile{
+			{Name: "pool1", Count: 10, OSType: Linux, VnetSubnetID: vnetSubnetID("sub1", "rg1", "vnet1", "subnetA")},
+		},
+	}
+
+	err := validateSubnetCIDR(props)
+	if err == nil {
+		t.Fatal("expected a /30 subnet to be too small for 10 nodes, got nil")
+	}
+	if !strings.Contains(err.Error(), "pool1") {
+		t.Fatalf("expected error to name the offending pool, got %v", err)
+	}
+}
+
+// TestValidateSubnetCIDRFailsWhenSubnetAlreadyAllocated guards against sizing a subnet purely
+// off its CIDR block: a /24 has plenty of raw room for 3 nodes, but if other NICs have already
+// claimed most of its addresses there isn't actually space left, and the check must catch that
+// instead of only ever looking at the block size.
+func TestValidateSubnetCIDRFailsWhenSubnetAlreadyAllocated(t *testing.T) {
+	withFakeSubnetCIDR(t, fakeSubnetCIDRGetter{cidr: "10.0.0.0/24", allocatedIPs: 250}) // 256-5-250 = 1 free address
+
+	props := &Properties{
+		DNSPrefix: "dnsprefix",
+		AgentPoolProfiles: []*AgentPoolProfile{
+			{Name: "pool1", Count: 3, OSType: Linux, VnetSubnetID: vnetSubnetID("sub1", "rg1", "vnet1", "subnetA")},
+		},
+	}
+
+	err := validateSubnetCIDR(props)
+	if err == nil {
+		t.Fatal("expected a mostly-allocated /24 subnet to be rejected despite its raw block size, got nil")
+	}
+	if !strings.Contains(err.Error(), "pool1") {
+		t.Fatalf("expected error to name the offending pool, got %v", err)
+	}
+}
+
+// TestValidateSubnetCIDRSkipsNonCustomVNETPools guards against the bug where the rule engine
+// (which aggregates every rule's errors instead of stopping at the first) caused
+// validateSubnetCIDR to also run on a mixed custom/non-custom-VNET configuration already
+// rejected by validateVNET, unconditionally parsing the non-custom pool's empty VnetSubnetID and
+// producing a confusing, unrelated error alongside the real one.
+func TestValidateSubnetCIDRSkipsNonCustomVNETPools(t *testing.T) {
+	withFakeSubnetCIDR(t, fakeSubnetCIDRGetter{cidr: "10.0.0.0/24"})
+
+	props := &Properties{
+		DNSPrefix: "dnsprefix",
+		AgentPoolProfiles: []*AgentPoolProfile{
+			{Name: "pool1", Count: 3, OSType: Linux, VnetSubnetID: vnetSubnetID("sub1", "rg1", "vnet1", "subnetA")},
+			{Name: "pool2", Count: 3, OSType: Linux},
+		},
+	}
+
+	if err := validateSubnetCIDR(props); err != nil {
+		t.Fatalf("expected non-custom-VNET pools to be skipped rather than fail to parse, got %v", err)
+	}
+}
+
+// TestValidateSubnetCIDRChecksCapacityPerSubnet guards against summing Count across every agent
+// pool and checking that global total against each subnet: two pools of 50 nodes each, routed to
+// two different /26 subnets within the same VNET, must each be judged against their own 50 nodes
+// (55 required with reserved addresses), not the combined 100.
+func TestValidateSubnetCIDRChecksCapacityPerSubnet(t *testing.T) {
+	cidrBySubnet := map[string]string{
+		vnetSubnetID("sub1", "rg1", "vnet1", "subnetA"): "10.0.0.0/26",
+		vnetSubnetID("sub1", "rg1", "vnet1", "subnetB"): "10.0.1.0/26",
+	}
+	original := subnetCIDR
+	subnetCIDR = fakeSubnetCIDRLookup(cidrBySubnet)
+	t.Cleanup(func() { subnetCIDR = original })
+
+	props := &Properties{
+		DNSPrefix: "dnsprefix",
+		AgentPoolProfiles: []*AgentPoolProfile{
+			{Name: "pool1", Count: 50, OSType: Linux, VnetSubnetID: vnetSubnetID("sub1", "rg1", "vnet1", "subnetA")},
+			{Name: "pool2", Count: 50, OSType: Linux, VnetSubnetID: vnetSubnetID("sub1", "rg1", "vnet1", "subnetB")},
+		},
+	}
+
+	if err := validateSubnetCIDR(props); err != nil {
+		t.Fatalf("expected each /26 subnet to have enough capacity for its own 50 nodes, got %v", err)
+	}
+}
+
+type fakeSubnetCIDRLookup map[string]string
+
+func (f fakeSubnetCIDRLookup) GetSubnet(subscriptionID, resourceGroup, vnetName, subnetName string) (string, int, error) {
+	id := vnetSubnetID(subscriptionID, resourceGroup, vnetName, subnetName)
+	cidr, ok := f[id]
+	if !ok {
+		return "", 0, fmt.Errorf("no fake CIDR registered for subnet %q", id)
+	}
+	return cidr, 0, nil
+}
+
+func TestSubnetCapacityRuleDefersToVNETConsistencyRule(t *testing.T) {
+	withFakeSubnetCIDR(t, fakeSubnetCIDRGetter{cidr: "10.0.0.0/24"})
+
+	props := &Properties{
+		DNSPrefix: "dnsprefix",
+		AgentPoolProfiles: []*AgentPoolProfile{
+			{Name: "pool1", Count: 3, OSType: Linux, VnetSubnetID: vnetSubnetID("sub1", "rg1", "vnet1", "subnetA")},
+			{Name: "pool2", Count: 3, OSType: Linux},
+		},
+	}
+
+	if err := (subnetCapacityRule{}).Check(props); err != nil {
+		t.Fatalf("expected subnetCapacityRule to defer to vnetConsistencyRule's error rather than report its own, got %v", err)
+	}
+}
+
+func TestPoolNameLengthRuleDisablement(t *testing.T) {
+	pool := &AgentPoolProfile{Name: "toolongpoolname", Count: 1, OSType: Linux}
+	props := &Properties{AgentPoolProfiles: []*AgentPoolProfile{pool}}
+
+	err := rules.Validate(props)
+	if err == nil || !strings.Contains(err.Error(), "max length of 12") {
+		t.Fatalf("expected the built-in poolNameLength rule to reject an over-long pool name, got %v", err)
+	}
+
+	rules.UnregisterRule("poolNameLength")
+	defer rules.RegisterRule(poolNameLengthRule{})
+
+	if err := rules.Validate(props); err != nil && strings.Contains(err.Error(), "max length of 12") {
+		t.Fatalf("expected the poolNameLength error to be suppressed after rules.UnregisterRule, got %v", err)
+	}
+}
+
+// TestAgentPoolProfileValidateHonorsRuleDisablement guards against AgentPoolProfile.Validate()
+// enforcing maxPoolNameLength directly instead of through the rule registry: a caller going
+// through the APIObject interface on an individual pool, rather than through Properties.Validate,
+// must also see the cap lifted once poolNameLength is unregistered.
+func TestAgentPoolProfileValidateHonorsRuleDisablement(t *testing.T) {
+	pool := &AgentPoolProfile{Name: "toolongpoolname", Count: 1, OSType: Linux}
+
+	if err := pool.Validate(); err == nil || !strings.Contains(err.Error(), "max length of 12") {
+		t.Fatalf("expected AgentPoolProfile.Validate to reject an over-long pool name, got %v", err)
+	}
+
+	rules.UnregisterRule("poolNameLength")
+	defer rules.RegisterRule(poolNameLengthRule{})
+
+	if err := pool.Validate(); err != nil {
+		t.Fatalf("expected AgentPoolProfile.Validate to honor rules.UnregisterRule(\"poolNameLength\"), got %v", err)
+	}
+}